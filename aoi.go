@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// aoi is a lon/lat bounding box, used both as the -bbox flag value and as
+// the footprint derived from a scene's MTL corner coordinates.
+type aoi struct {
+	minLon, minLat, maxLon, maxLat float64
+	set                            bool
+}
+
+func (b *aoi) String() string {
+	if !b.set {
+		return ""
+	}
+	return fmt.Sprintf("%g,%g,%g,%g", b.minLon, b.minLat, b.maxLon, b.maxLat)
+}
+
+func (b *aoi) Set(value string) error {
+	parts := strings.Split(value, ",")
+	if 4 != len(parts) {
+		return fmt.Errorf("bbox must be minLon,minLat,maxLon,maxLat, got %q", value)
+	}
+	vals := make([]float64, 4)
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if nil != err {
+			return fmt.Errorf("bbox value %q: %v", p, err)
+		}
+		vals[i] = v
+	}
+	b.minLon, b.minLat, b.maxLon, b.maxLat = vals[0], vals[1], vals[2], vals[3]
+	b.set = true
+	return nil
+}
+
+func (b *aoi) intersects(o aoi) bool {
+	return b.minLon <= o.maxLon && b.maxLon >= o.minLon && b.minLat <= o.maxLat && b.maxLat >= o.minLat
+}
+
+var mtlCorners = []string{
+	"CORNER_UL_LAT_PRODUCT", "CORNER_UL_LON_PRODUCT",
+	"CORNER_UR_LAT_PRODUCT", "CORNER_UR_LON_PRODUCT",
+	"CORNER_LL_LAT_PRODUCT", "CORNER_LL_LON_PRODUCT",
+	"CORNER_LR_LAT_PRODUCT", "CORNER_LR_LON_PRODUCT",
+}
+
+// sceneFootprint parses the CORNER_*_PRODUCT lines out of an MTL file into
+// the bounding box of the scene's four corners.
+func sceneFootprint(mtlPath string) (aoi, error) {
+	f, err := os.Open(mtlPath)
+	if nil != err {
+		return aoi{}, err
+	}
+	defer f.Close()
+
+	lats := make([]float64, 0, 4)
+	lons := make([]float64, 0, 4)
+	wanted := make(map[string]bool, len(mtlCorners))
+	for _, c := range mtlCorners {
+		wanted[c] = true
+	}
+
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		l := strings.Trim(lines.Text(), " ")
+		parts := strings.SplitN(l, " = ", 2)
+		if 2 != len(parts) || !wanted[strings.TrimSpace(parts[0])] {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if nil != err {
+			continue
+		}
+		if strings.Contains(parts[0], "_LAT_") {
+			lats = append(lats, v)
+		} else {
+			lons = append(lons, v)
+		}
+	}
+	if 4 != len(lats) || 4 != len(lons) {
+		return aoi{}, fmt.Errorf("%s: missing corner coordinates", mtlPath)
+	}
+
+	box := aoi{minLat: lats[0], maxLat: lats[0], minLon: lons[0], maxLon: lons[0], set: true}
+	for _, v := range lats {
+		if v < box.minLat {
+			box.minLat = v
+		}
+		if v > box.maxLat {
+			box.maxLat = v
+		}
+	}
+	for _, v := range lons {
+		if v < box.minLon {
+			box.minLon = v
+		}
+		if v > box.maxLon {
+			box.maxLon = v
+		}
+	}
+	return box, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAoiIntersects(t *testing.T) {
+	base := aoi{minLon: -10, minLat: -10, maxLon: 10, maxLat: 10}
+	cases := []struct {
+		name string
+		o    aoi
+		want bool
+	}{
+		{"identical box", base, true},
+		{"contained box", aoi{minLon: -1, minLat: -1, maxLon: 1, maxLat: 1}, true},
+		{"overlapping corner", aoi{minLon: 5, minLat: 5, maxLon: 15, maxLat: 15}, true},
+		{"touching edge", aoi{minLon: 10, minLat: -10, maxLon: 20, maxLat: 10}, true},
+		{"disjoint to the east", aoi{minLon: 11, minLat: -10, maxLon: 20, maxLat: 10}, false},
+		{"disjoint to the north", aoi{minLon: -10, minLat: 11, maxLon: 10, maxLat: 20}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := base.intersects(c.o); got != c.want {
+				t.Errorf("intersects(%+v) = %v, want %v", c.o, got, c.want)
+			}
+		})
+	}
+}
+
+func TestSceneFootprint(t *testing.T) {
+	mtl := `GROUP = L1_METADATA_FILE
+    CORNER_UL_LAT_PRODUCT = 40.5
+    CORNER_UL_LON_PRODUCT = -105.5
+    CORNER_UR_LAT_PRODUCT = 40.6
+    CORNER_UR_LON_PRODUCT = -103.5
+    CORNER_LL_LAT_PRODUCT = 38.5
+    CORNER_LL_LON_PRODUCT = -105.4
+    CORNER_LR_LAT_PRODUCT = 38.6
+    CORNER_LR_LON_PRODUCT = -103.4
+END_GROUP = L1_METADATA_FILE
+`
+	dir := t.TempDir()
+	path := filepath.Join(dir, "MTL.txt")
+	if err := os.WriteFile(path, []byte(mtl), 0644); nil != err {
+		t.Fatal(err)
+	}
+	box, err := sceneFootprint(path)
+	if nil != err {
+		t.Fatalf("sceneFootprint: %v", err)
+	}
+	want := aoi{minLon: -105.5, minLat: 38.5, maxLon: -103.4, maxLat: 40.6, set: true}
+	if box != want {
+		t.Errorf("sceneFootprint = %+v, want %+v", box, want)
+	}
+}
+
+func TestSceneFootprintMissingCorners(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "MTL.txt")
+	if err := os.WriteFile(path, []byte("CORNER_UL_LAT_PRODUCT = 40.5\n"), 0644); nil != err {
+		t.Fatal(err)
+	}
+	if _, err := sceneFootprint(path); nil == err {
+		t.Fatal("expected an error for a file missing corner coordinates")
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/csv"
 	"errors"
 	"flag"
@@ -10,11 +11,17 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/time/rate"
 )
 
 var (
@@ -151,16 +158,78 @@ type HttpRepository struct {
 	CachePath   string
 	NoCacheMeta bool
 	CacheBands  bool
+	Ctx         context.Context
+	Progress    *progressRegistry
+	Retries     int
+	QPS         float64
+	Burst       int
+
+	limitersMu sync.Mutex
+	limiters   map[string]*rate.Limiter
+
+	noSidecarMu sync.Mutex
+	noSidecar   map[string]bool
 }
 
-func (r *HttpRepository) GetScene(rec []string) (Scene, error) {
+func (r *HttpRepository) ctx() context.Context {
+	if nil == r.Ctx {
+		return context.Background()
+	}
+	return r.Ctx
+}
+
+func (r *HttpRepository) retries() int {
+	if r.Retries <= 0 {
+		return 1
+	}
+	return r.Retries
+}
+
+// limiter returns the shared token-bucket rate limiter for host, creating
+// it on first use from the repository's configured QPS/burst.
+func (r *HttpRepository) limiter(host string) *rate.Limiter {
+	r.limitersMu.Lock()
+	defer r.limitersMu.Unlock()
+	if nil == r.limiters {
+		r.limiters = make(map[string]*rate.Limiter)
+	}
+	l, ok := r.limiters[host]
+	if !ok {
+		qps := r.QPS
+		if qps <= 0 {
+			qps = 4
+		}
+		burst := r.Burst
+		if burst <= 0 {
+			burst = int(qps)
+			if burst < 1 {
+				burst = 1
+			}
+		}
+		l = rate.NewLimiter(rate.Limit(qps), burst)
+		r.limiters[host] = l
+	}
+	return l
+}
+
+// sceneFields holds the columns of the scene_list.csv row common to every
+// Repository implementation, parsed once and reused by each backend's
+// GetScene.
+type sceneFields struct {
+	id         string
+	acqDate    time.Time
+	cloudCover float64
+	level      plevel
+}
+
+func parseSceneFields(rec []string) (sceneFields, error) {
 	accDate, err := time.Parse(lstformat, rec[1])
 	if nil != err {
-		return nil, err
+		return sceneFields{}, err
 	}
 	cc, err := strconv.ParseFloat(rec[2], 64)
 	if nil != err {
-		return nil, err
+		return sceneFields{}, err
 	}
 	var pl plevel
 	if "L1T" == rec[3] {
@@ -168,65 +237,192 @@ func (r *HttpRepository) GetScene(rec []string) (Scene, error) {
 	} else if "L1GT" == rec[3] {
 		pl = L1GT
 	} else {
-		return nil, UnexpectedLevel
+		return sceneFields{}, UnexpectedLevel
+	}
+	return sceneFields{rec[0], accDate, cc, pl}, nil
+}
+
+func (r *HttpRepository) GetScene(rec []string) (Scene, error) {
+	f, err := parseSceneFields(rec)
+	if nil != err {
+		return nil, err
 	}
 	return &httpScene{
 		r,
-		rec[0],
-		accDate,
-		cc,
-		pl,
+		f.id,
+		f.acqDate,
+		f.cloudCover,
+		f.level,
 		strings.TrimSuffix(rec[10], `index.html`),
 		nil,
 	}, nil
 }
 
-func (r *HttpRepository) get(url string, cached string, cache bool) (io.ReadCloser, error) {
+// get downloads url to cached, retrying transient failures (connection
+// resets, timeouts, 5xx responses) with backoff and jitter while giving up
+// immediately on permanent ones (404, 401, ...). Each attempt resumes from
+// a ".part" sibling file via an HTTP Range request when a previous attempt
+// was interrupted partway through. When cache is false the finished file
+// is unlinked again right after being opened, so the caller still gets to
+// read it but nothing is left behind on disk once it is closed. kind,
+// sceneId and band identify the download for logDownload/retryTotal, which
+// are fed from every attempt, not just the last one.
+func (r *HttpRepository) get(url string, cached string, cache bool, progressKey string, kind string, sceneId string, band int) (io.ReadCloser, error) {
+	if _, err := os.Stat(cached); nil == err {
+		log.Printf("Cached copy found: %s\b", cached)
+		return r.open(cached, cache)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
 	var err error
-	_, err = os.Stat(cached)
-	if os.IsNotExist(err) {
-		res, err := http.Get(url)
-		if !cache {
-			return res.Body, err
-		}
-		if nil != err {
-			log.Printf("Request failed: %v\n", err)
-			return nil, err
-		}
-		defer res.Body.Close()
-		if nil != err {
-			log.Printf("Download failed: %v\n", err)
-			return nil, err
+	var retryAfter string
+	for attempt := 1; attempt <= r.retries(); attempt++ {
+		start := time.Now()
+		var rc io.ReadCloser
+		var n int64
+		rc, n, retryAfter, err = r.attemptGet(url, cached, cache, progressKey)
+		logDownload(kind, sceneId, band, attempt, n, time.Since(start), err)
+		if nil == err {
+			return rc, nil
 		}
-		out, err := os.Create(cached)
-		defer out.Close()
-		if nil != err {
-			log.Printf("Local file creation failed: %s\n", cached)
-			return nil, err
+		if !isTransient(err) || attempt == r.retries() {
+			break
 		}
-		_, err = io.Copy(out, res.Body)
-		if nil != err {
-			log.Printf("Failed to save remote data: %v\n", err)
+		delay := retryDelay(retryAfter, attempt)
+		logger.Warn().Str("url", url).Int("attempt", attempt).Dur("retry_in", delay).Err(err).Msg("retrying download")
+		select {
+		case <-time.After(delay):
+		case <-r.ctx().Done():
+			return nil, r.ctx().Err()
 		}
+	}
+	return nil, err
+}
+
+// attemptGet performs a single download attempt. retryAfter carries the
+// server's Retry-After header back to the caller so a 429/503 response can
+// be honored even though the rest of the response is discarded on error. n
+// reports the bytes copied during this attempt so callers can log it even
+// when the attempt ultimately fails.
+func (r *HttpRepository) attemptGet(url string, cached string, cache bool, progressKey string) (_ io.ReadCloser, n int64, retryAfter string, _ error) {
+	partial := cached + ".part"
+	var offset int64
+	if fi, err := os.Stat(partial); nil == err {
+		offset = fi.Size()
+	}
+
+	req, err := http.NewRequestWithContext(r.ctx(), "GET", url, nil)
+	if nil != err {
+		return nil, 0, "", err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	if err := r.limiter(req.URL.Host).Wait(r.ctx()); nil != err {
+		return nil, 0, "", err
+	}
+	res, err := r.Client.Do(req)
+	if nil != err {
+		log.Printf("Request failed: %v\n", err)
+		return nil, 0, "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 400 {
+		return nil, 0, res.Header.Get("Retry-After"), &httpStatusError{res.StatusCode}
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if http.StatusPartialContent == res.StatusCode {
+		flags |= os.O_APPEND
 	} else {
-		log.Printf("Cached copy found: %s\b", cached)
+		// The server ignored our Range header (or this is a fresh
+		// download): start the file over from scratch.
+		flags |= os.O_TRUNC
+		offset = 0
+	}
+	out, err := os.OpenFile(partial, flags, 0644)
+	if nil != err {
+		log.Printf("Local file creation failed: %s\n", partial)
+		return nil, 0, "", err
+	}
+	defer out.Close()
+
+	total := res.ContentLength
+	if total >= 0 {
+		total += offset
+	}
+	body := r.progress(progressKey, total, res.Body)
+	n, err = io.Copy(out, body)
+	if nil != r.Progress {
+		r.Progress.done(progressKey)
+	}
+	if nil != err {
+		log.Printf("Failed to save remote data: %v\n", err)
+		if nil != r.ctx().Err() {
+			// Shutting down: don't leave a half-written file behind.
+			removePartial(partial)
+		}
+		return nil, n, "", err
+	}
+	out.Close()
+
+	if err := r.verify(url, partial); nil != err {
+		log.Printf("Checksum verification failed for %s: %v\n", partial, err)
+		removePartial(partial)
+		return nil, n, "", err
+	}
+
+	if err := os.Rename(partial, cached); nil != err {
+		return nil, n, "", err
+	}
+	rc, err := r.open(cached, cache)
+	return rc, n, "", err
+}
+
+// open returns a handle to cached. When cache is false the directory entry
+// is removed immediately, but the already-open file descriptor keeps the
+// data readable until the caller closes it.
+func (r *HttpRepository) open(cached string, cache bool) (io.ReadCloser, error) {
+	f, err := os.Open(cached)
+	if nil != err {
+		return nil, err
+	}
+	if !cache {
+		removePartial(cached)
+	}
+	return f, nil
+}
+
+func (r *HttpRepository) progress(key string, total int64, rc io.Reader) io.Reader {
+	if nil == r.Progress {
+		return rc
+	}
+	return r.Progress.reader(key, total, rc)
+}
+
+// removePartial discards a file left behind by an interrupted or failed
+// download so a later run does not mistake it for a complete cached copy.
+func removePartial(path string) {
+	if err := os.Remove(path); nil != err && !os.IsNotExist(err) {
+		log.Printf("Failed to remove partial file %s: %v\n", path, err)
 	}
-	return os.Open(cached)
 }
 
 func (r *HttpRepository) getMeta(id string, baseUrl string) (io.ReadCloser, error) {
 	fname := id + "_MTL.txt"
-	return r.get(baseUrl+fname, filepath.Join(r.CachePath, metaDir, id+fname), !r.NoCacheMeta)
+	return r.get(baseUrl+fname, filepath.Join(r.CachePath, metaDir, id+fname), !r.NoCacheMeta, id+"/MTL", "meta", id, 0)
 }
 
 func (r *HttpRepository) getBand(id string, band int, baseUrl string) (io.ReadCloser, error) {
 	fname := fmt.Sprintf("%s_B%d.TIF", id, band)
-	return r.get(baseUrl+fname, filepath.Join(r.CachePath, bandDir, fname), r.CacheBands)
+	return r.get(baseUrl+fname, filepath.Join(r.CachePath, bandDir, fname), r.CacheBands, fmt.Sprintf("%s/B%d", id, band), "band", id, band)
 }
 
 func (r *HttpRepository) getBQA(id string, baseUrl string) (io.ReadCloser, error) {
 	fname := id + "_BQA.TIF"
-	return r.get(baseUrl+fname, filepath.Join(r.CachePath, bandDir, fname), r.CacheBands)
+	return r.get(baseUrl+fname, filepath.Join(r.CachePath, bandDir, fname), r.CacheBands, id+"/BQA", "bqa", id, 0)
 }
 
 var zerod = Time{}
@@ -242,6 +438,16 @@ var retries int
 var bands ints
 var bqa bool
 var dpath string
+var silent bool
+var noProgress bool
+var source string
+var doMask bool
+var maskCloudConf int
+var maskShadowConf int
+var targetAOI aoi
+var metricsAddr string
+var qps float64
+var burst int
 
 func main() {
 	flag.Var(&from, "f", "Lookup scenes starting from this day.")
@@ -255,12 +461,38 @@ func main() {
 	flag.StringVar(&dpath, "p", "download", "Path where data will be stored.")
 	flag.DurationVar(&timeout, "timeout", 20*time.Minute, "HTTP request timeout.")
 	flag.IntVar(&retries, "retries", 3, "Number of save retries.")
+	flag.BoolVar(&silent, "silent", false, "Suppress all console output.")
+	flag.BoolVar(&noProgress, "no-progress", false, "Disable progress bars, keep log output.")
+	flag.StringVar(&source, "source", "usgs", "Scene source: usgs, aws or gcs.")
+	flag.BoolVar(&doMask, "mask", false, "Mask cloud/shadow/fill pixels out of each downloaded band using the BQA file.")
+	flag.IntVar(&maskCloudConf, "mask-cloud-confidence", 2, "Cloud confidence (0-3) at or above which a pixel is masked.")
+	flag.IntVar(&maskShadowConf, "mask-shadow-confidence", 2, "Cloud shadow confidence (0-3) at or above which a pixel is masked.")
+	flag.Var(&targetAOI, "bbox", "minLon,minLat,maxLon,maxLat; skip scenes whose footprint does not intersect it.")
+	flag.StringVar(&metricsAddr, "metrics", "", "Address to serve Prometheus metrics on, e.g. :9090. Disabled if empty.")
+	flag.Float64Var(&qps, "qps", 4, "Requests per second allowed per host.")
+	flag.IntVar(&burst, "burst", 8, "Per-host request burst allowed on top of -qps.")
 	flag.Parse()
 
+	if doMask && !bqa {
+		bqa = true
+		log.Printf("Enabling -bqa implicitly since -mask requires the BQA file\n")
+	}
+
 	if nworkers <= 0 {
 		nworkers = 1
 	}
 
+	if silent {
+		log.SetOutput(io.Discard)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if "" != metricsAddr {
+		startMetricsServer(ctx, metricsAddr)
+	}
+
 	fr, err := os.Open(sceneFile)
 	if nil != err {
 		panic(err)
@@ -280,136 +512,37 @@ func main() {
 		panic(err)
 	}
 
-	repo := HttpRepository{
+	base := HttpRepository{
 		Client: http.Client{
 			Timeout: timeout,
 		},
+		Ctx:      ctx,
+		Progress: newProgressRegistry(!silent && !noProgress),
+		Retries:  retries,
+		QPS:      qps,
+		Burst:    burst,
+	}
+	defer base.Progress.close()
+
+	var repo Repository
+	switch source {
+	case "usgs":
+		repo = &base
+	case "aws":
+		repo = NewS3Repository(&base)
+	case "gcs":
+		repo = NewGCSRepository(&base)
+	default:
+		panic(fmt.Sprintf("Unknown source %q, expected usgs, aws or gcs", source))
 	}
 
-	queue := make(chan []string, nworkers)
+	// sem bounds the total number of in-flight HTTP requests across every
+	// scene; within a scene, BQA and band downloads race each other for a
+	// slot instead of running strictly one after another.
+	sem := semaphore.NewWeighted(int64(nworkers))
 	ids := make(chan string, nworkers)
-	var wg sync.WaitGroup
 	var wg2 sync.WaitGroup
 
-	for i := 0; i < nworkers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for rec := range queue {
-				func() {
-					var rtrs int
-					var err error
-					var day bool
-					scene, err := repo.GetScene(rec)
-					if nil != err {
-						log.Printf("Failed to parse scene record: %s\n", err)
-						return
-					}
-					if scene.Acquisition().Before(time.Time(from)) || scene.Acquisition().After(time.Time(to)) {
-						return
-					}
-					for rtrs = retries; rtrs > 0; rtrs-- {
-						day, err = scene.IsDay()
-						if nil == err {
-							break
-						}
-					}
-					if 0 == rtrs {
-						log.Printf("Failed to determine if scene is nighttime: %v\n", err)
-						return
-					}
-
-					if !day {
-						dir := filepath.Join(dpath, scene.Id())
-						err = os.MkdirAll(dir, os.ModeDir|os.ModePerm)
-						if nil != err {
-							log.Printf("Failed to create storage directory: %v\n", err)
-							return
-						}
-						fname := scene.Id() + "_MTL.txt"
-						fpath := filepath.Join(dir, fname)
-						for rtrs = retries; rtrs > 0; rtrs-- {
-							out, err := os.Create(fpath)
-							defer out.Close()
-							if nil != err {
-								log.Printf("Failed to create meta output file: %v\n", err)
-								return
-							}
-							meta, err := scene.GetMeta()
-							if nil != err {
-								continue
-							}
-							_, err = io.Copy(out, meta)
-							if nil != err {
-								continue
-							}
-							break
-						}
-						if 0 == rtrs {
-							log.Printf("Failed to download meta: %v\n", err)
-							return
-						}
-
-						if bqa {
-							fname := scene.Id() + "_BQA.TIF"
-							fpath := filepath.Join(dir, fname)
-							for rtrs = retries; rtrs > 0; rtrs-- {
-								out, err := os.Create(fpath)
-								defer out.Close()
-								if nil != err {
-									log.Printf("Failed to create BQA output file: %v\n", err)
-									return
-								}
-								qual, err := scene.GetBQA()
-								if nil != err {
-									continue
-								}
-								_, err = io.Copy(out, qual)
-								if nil != err {
-									continue
-								}
-								break
-							}
-							if 0 == rtrs {
-								log.Printf("Failed to download bqa: %v\n", err)
-								return
-							}
-						}
-
-						for _, band := range bands {
-							fname := fmt.Sprintf("%s_B%d.TIF", scene.Id(), band)
-							fpath := filepath.Join(dir, fname)
-							for rtrs = retries; rtrs > 0; rtrs-- {
-								out, err := os.Create(fpath)
-								defer out.Close()
-								if nil != err {
-									log.Printf("Failed to create band output file: %v\n", err)
-									return
-								}
-								ban, err := scene.GetBand(band)
-								if nil != err {
-									continue
-								}
-								_, err = io.Copy(out, ban)
-								if nil != err {
-									continue
-								}
-								break
-							}
-							if 0 == rtrs {
-								log.Printf("Failed to download band: %v\n", err)
-								return
-							}
-						}
-
-						log.Printf("Done with %s\n", scene.Id())
-						ids <- scene.Id()
-					}
-				}()
-			}
-		}()
-	}
-
 	wg2.Add(1)
 	go func() {
 		defer wg2.Done()
@@ -422,16 +555,33 @@ func main() {
 		}
 	}()
 
+	// Capped at nworkers so the CSV read loop can't outrun processing and
+	// spawn one goroutine per row up front; sem still bounds the narrower
+	// case of concurrent HTTP requests within and across those goroutines.
+	var eg errgroup.Group
+	eg.SetLimit(nworkers)
+readLoop:
 	for fields, err = csvr.Read(); io.EOF != err; fields, err = csvr.Read() {
 		if nil != err {
 			log.Printf("Error reading csv line: %v\n", err)
 			continue
 		}
-		queue <- fields
+		rec := fields
+		select {
+		case <-ctx.Done():
+			log.Printf("Shutdown requested, no longer queueing new scenes\n")
+			break readLoop
+		default:
+		}
+		eg.Go(func() error {
+			downloadScene(ctx, repo, sem, rec, ids)
+			return nil
+		})
 	}
-	close(queue)
-	wg.Wait()
+	eg.Wait()
 	close(ids)
 	wg2.Wait()
-	rw.Close()
+	if err := rw.Close(); nil != err {
+		log.Printf("Failed to flush result file: %v\n", err)
+	}
 }
@@ -0,0 +1,81 @@
+package main
+
+import "errors"
+
+var errBandBQASizeMismatch = errors.New("band and BQA raster dimensions do not match")
+
+// Landsat 8 Collection 1 QA bit layout used for masking, per the BQA band
+// documentation: bit 0 fill, bit 4 cloud, bits 7-8 cloud confidence, bits
+// 10-11 cloud shadow confidence. Confidence fields are 2-bit values from 0
+// (not determined) to 3 (high confidence).
+const (
+	qaFillBit         = 0
+	qaCloudBit        = 4
+	qaCloudConfShift  = 7
+	qaShadowConfShift = 10
+	qaConfMask        = 0x3
+)
+
+func qaCloudConfidence(qa uint16) int {
+	return int((qa >> qaCloudConfShift) & qaConfMask)
+}
+
+func qaShadowConfidence(qa uint16) int {
+	return int((qa >> qaShadowConfShift) & qaConfMask)
+}
+
+// maskPixel reports whether a pixel should be replaced with nodata: it is
+// filled, flagged as cloud, or its cloud/shadow confidence meets or exceeds
+// the configured thresholds.
+func maskPixel(qa uint16, cloudConfThreshold, shadowConfThreshold int) bool {
+	if 0 != qa&(1<<qaFillBit) {
+		return true
+	}
+	if 0 != qa&(1<<qaCloudBit) {
+		return true
+	}
+	if qaCloudConfidence(qa) >= cloudConfThreshold {
+		return true
+	}
+	if qaShadowConfidence(qa) >= shadowConfThreshold {
+		return true
+	}
+	return false
+}
+
+const nodataValue = uint16(0)
+
+// maskBand reads the BQA file at bqaPath and the band file at bandPath and
+// writes a copy of the band to outPath with every masked pixel set to
+// nodataValue. The two files must describe rasters of the same dimensions,
+// which holds for same-scene Landsat 8 products.
+func maskBand(bqaPath, bandPath, outPath string, cloudConfThreshold, shadowConfThreshold int) error {
+	qa, err := readRawTiff(bqaPath)
+	if nil != err {
+		return err
+	}
+	band, err := readRawTiff(bandPath)
+	if nil != err {
+		return err
+	}
+	if qa.width != band.width || qa.height != band.height {
+		return errBandBQASizeMismatch
+	}
+	qaPixels, err := qa.pixels()
+	if nil != err {
+		return err
+	}
+	bandPixels, err := band.pixels()
+	if nil != err {
+		return err
+	}
+	if len(qaPixels) != len(bandPixels) {
+		return errBandBQASizeMismatch
+	}
+	for i, v := range qaPixels {
+		if maskPixel(v, cloudConfThreshold, shadowConfThreshold) {
+			bandPixels[i] = nodataValue
+		}
+	}
+	return band.writeMasked(outPath, bandPixels)
+}
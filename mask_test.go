@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestMaskPixel(t *testing.T) {
+	cases := []struct {
+		name                                    string
+		qa                                      uint16
+		cloudConfThreshold, shadowConfThreshold int
+		want                                    bool
+	}{
+		{"clear pixel", 0, 2, 2, false},
+		{"fill bit set", 1 << qaFillBit, 2, 2, true},
+		{"cloud bit set", 1 << qaCloudBit, 2, 2, true},
+		{"cloud confidence below threshold", 1 << qaCloudConfShift, 2, 2, false},
+		{"cloud confidence meets threshold", 2 << qaCloudConfShift, 2, 2, true},
+		{"cloud confidence exceeds threshold", 3 << qaCloudConfShift, 2, 2, true},
+		{"shadow confidence below threshold", 1 << qaShadowConfShift, 3, 3, false},
+		{"shadow confidence meets threshold", 3 << qaShadowConfShift, 3, 3, true},
+		{"high thresholds ignore low confidence", 1<<qaCloudConfShift | 1<<qaShadowConfShift, 3, 3, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := maskPixel(c.qa, c.cloudConfThreshold, c.shadowConfThreshold); got != c.want {
+				t.Errorf("maskPixel(%#04x, %d, %d) = %v, want %v", c.qa, c.cloudConfThreshold, c.shadowConfThreshold, got, c.want)
+			}
+		})
+	}
+}
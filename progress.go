@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"sync"
+
+	"github.com/cheggaaa/pb/v3"
+)
+
+// progressRegistry tracks one bar per scene/kind download plus a single
+// aggregate bar summing bytes across every active transfer. It is safe for
+// concurrent use by the worker goroutines.
+type progressRegistry struct {
+	enabled bool
+	pool    *pb.Pool
+	total   *pb.ProgressBar
+	mu      sync.Mutex
+	bars    map[string]*pb.ProgressBar
+	// contributed tracks which keys have already added their size to total,
+	// so a retried attempt (whose bar was removed by done) doesn't inflate
+	// the aggregate denominator a second time.
+	contributed map[string]bool
+}
+
+func newProgressRegistry(enabled bool) *progressRegistry {
+	r := &progressRegistry{enabled: enabled, bars: make(map[string]*pb.ProgressBar), contributed: make(map[string]bool)}
+	if !enabled {
+		return r
+	}
+	r.total = pb.New64(0).SetTemplateString(`Total: {{counters . }} {{bar . }} {{speed . }}`)
+	pool, err := pb.StartPool(r.total)
+	if nil == err {
+		r.pool = pool
+	}
+	return r
+}
+
+// reader wraps rc so that every byte read is accounted for on the bar keyed
+// by key (typically "<sceneId>/<kind>") and on the aggregate bar. total is
+// the expected size, usually taken from the response's Content-Length. Only
+// the first call for a given key ever contributes to the aggregate bar's
+// denominator, since retries reuse the same key.
+func (r *progressRegistry) reader(key string, total int64, rc io.Reader) io.Reader {
+	if !r.enabled {
+		return rc
+	}
+	r.mu.Lock()
+	bar, ok := r.bars[key]
+	if !ok {
+		bar = pb.New64(total).SetTemplateString(key + `: {{counters . }} {{bar . }} {{speed . }}`)
+		r.bars[key] = bar
+		if nil != r.pool {
+			r.pool.Add(bar)
+		}
+		bar.Start()
+		if nil != r.total && !r.contributed[key] {
+			r.total.SetTotal(r.total.Total() + total)
+			r.contributed[key] = true
+		}
+	}
+	r.mu.Unlock()
+	return bar.NewProxyReader(rc)
+}
+
+// done finishes and removes the bar for key, e.g. once a download completes
+// or is abandoned after exhausting its retries.
+func (r *progressRegistry) done(key string) {
+	if !r.enabled {
+		return
+	}
+	r.mu.Lock()
+	bar, ok := r.bars[key]
+	if ok {
+		bar.Finish()
+		delete(r.bars, key)
+	}
+	r.mu.Unlock()
+}
+
+func (r *progressRegistry) close() {
+	if !r.enabled {
+		return
+	}
+	if nil != r.total {
+		r.total.Finish()
+	}
+	if nil != r.pool {
+		r.pool.Stop()
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	awsBucket = "landsat-pds"
+	gcsBucket = "gcp-public-data-landsat"
+)
+
+// pathRow returns the WRS-2 path/row for a scene, preferring the CSV's own
+// path/row columns and falling back to parsing them out of the scene id
+// (LXSPPPRRRYYYYDDDGSIVV) for scene lists that don't carry them.
+func pathRow(rec []string) (path int, row int, err error) {
+	if len(rec) > 5 {
+		if path, err = strconv.Atoi(rec[4]); nil == err {
+			if row, err = strconv.Atoi(rec[5]); nil == err {
+				return path, row, nil
+			}
+		}
+	}
+	if len(rec[0]) < 9 {
+		return 0, 0, fmt.Errorf("scene id %q too short to contain a path/row", rec[0])
+	}
+	if path, err = strconv.Atoi(rec[0][3:6]); nil != err {
+		return 0, 0, fmt.Errorf("parsing path from scene id %q: %w", rec[0], err)
+	}
+	if row, err = strconv.Atoi(rec[0][6:9]); nil != err {
+		return 0, 0, fmt.Errorf("parsing row from scene id %q: %w", rec[0], err)
+	}
+	return path, row, nil
+}
+
+// S3Repository fetches scenes from the public landsat-pds bucket on AWS S3.
+// The bucket allows anonymous reads, so every request goes out unsigned.
+// AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY are not read or sent; signing
+// requests with them is out of scope until a non-public bucket is needed.
+type S3Repository struct {
+	*HttpRepository
+	Bucket string
+}
+
+func NewS3Repository(base *HttpRepository) *S3Repository {
+	return &S3Repository{HttpRepository: base, Bucket: awsBucket}
+}
+
+func (s *S3Repository) GetScene(rec []string) (Scene, error) {
+	f, err := parseSceneFields(rec)
+	if nil != err {
+		return nil, err
+	}
+	path, row, err := pathRow(rec)
+	if nil != err {
+		return nil, err
+	}
+	baseUrl := fmt.Sprintf("https://%s.s3.amazonaws.com/c1/L8/%03d/%03d/%s/", s.Bucket, path, row, f.id)
+	return &httpScene{s.HttpRepository, f.id, f.acqDate, f.cloudCover, f.level, baseUrl, nil}, nil
+}
+
+// GCSRepository fetches scenes from the public gcp-public-data-landsat
+// bucket on Google Cloud Storage. Like S3Repository it relies on the
+// bucket's anonymous read access rather than signing requests with
+// GOOGLE_APPLICATION_CREDENTIALS.
+type GCSRepository struct {
+	*HttpRepository
+	Bucket string
+}
+
+func NewGCSRepository(base *HttpRepository) *GCSRepository {
+	return &GCSRepository{HttpRepository: base, Bucket: gcsBucket}
+}
+
+func (g *GCSRepository) GetScene(rec []string) (Scene, error) {
+	f, err := parseSceneFields(rec)
+	if nil != err {
+		return nil, err
+	}
+	path, row, err := pathRow(rec)
+	if nil != err {
+		return nil, err
+	}
+	baseUrl := fmt.Sprintf("https://storage.googleapis.com/%s/c1/L8/%03d/%03d/%s/", g.Bucket, path, row, f.id)
+	return &httpScene{g.HttpRepository, f.id, f.acqDate, f.cloudCover, f.level, baseUrl, nil}, nil
+}
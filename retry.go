@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// httpStatusError wraps a non-2xx/3xx HTTP response so callers can tell a
+// permanent rejection (4xx) from a transient one (5xx) without re-parsing
+// the status code everywhere.
+type httpStatusError struct {
+	status int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("unexpected HTTP status %d", e.status)
+}
+
+// isTransient reports whether a failed download attempt is worth retrying.
+// Network-level failures (connection reset, timeout, DNS) and 5xx
+// responses are transient; 4xx responses such as 404 or 401 are permanent,
+// since retrying them just burns through the retry budget for nothing.
+func isTransient(err error) bool {
+	se, ok := err.(*httpStatusError)
+	if !ok {
+		return true
+	}
+	return se.status >= 500
+}
+
+// retryDelay computes the wait before the next attempt, honoring the
+// server's Retry-After header when present and otherwise backing off
+// exponentially with jitter so a pool of workers hitting the same host
+// don't all retry in lockstep.
+func retryDelay(retryAfter string, attempt int) time.Duration {
+	if "" != retryAfter {
+		if secs, err := strconv.Atoi(retryAfter); nil == err {
+			return time.Duration(secs) * time.Second
+		}
+	}
+	base := 200 * time.Millisecond << uint(attempt)
+	if base > 30*time.Second {
+		base = 30 * time.Second
+	}
+	return base/2 + time.Duration(rand.Int63n(int64(base/2+1)))
+}
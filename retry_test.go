@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIsTransient(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"network error", errors.New("connection reset"), true},
+		{"500", &httpStatusError{status: 500}, true},
+		{"503", &httpStatusError{status: 503}, true},
+		{"404", &httpStatusError{status: 404}, false},
+		{"401", &httpStatusError{status: 401}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isTransient(c.err); got != c.want {
+				t.Errorf("isTransient(%v) = %v, want %v", c.err, got, c.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelayHonorsRetryAfter(t *testing.T) {
+	got := retryDelay("5", 0)
+	if want := 5 * time.Second; got != want {
+		t.Errorf("retryDelay(%q, 0) = %v, want %v", "5", got, want)
+	}
+}
+
+func TestRetryDelayBacksOffAndCaps(t *testing.T) {
+	for attempt := 0; attempt < 10; attempt++ {
+		d := retryDelay("", attempt)
+		if d < 0 || d > 30*time.Second {
+			t.Errorf("retryDelay(\"\", %d) = %v, want within [0, 30s]", attempt, d)
+		}
+	}
+}
+
+func TestRetryDelayIgnoresUnparseableRetryAfter(t *testing.T) {
+	d := retryDelay("not-a-number", 0)
+	if d < 0 || d > 30*time.Second {
+		t.Errorf("retryDelay with unparseable Retry-After = %v, want within [0, 30s]", d)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog"
+)
+
+// logger emits one JSON record per download attempt so the tool can be run
+// as a long-lived job and have its output parsed instead of grepped.
+var logger = zerolog.New(os.Stderr).With().Timestamp().Logger()
+
+var (
+	scenesProcessedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "scenes_processed_total",
+		Help: "Scenes fully processed, by result.",
+	}, []string{"result"})
+
+	bytesDownloadedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bytes_downloaded_total",
+		Help: "Bytes downloaded, by kind (meta, bqa, band).",
+	}, []string{"kind"})
+
+	downloadDurationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "download_duration_seconds",
+		Help: "Download duration in seconds, by kind.",
+	}, []string{"kind"})
+
+	retryTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "retry_total",
+		Help: "Download retries, by kind.",
+	}, []string{"kind"})
+
+	inFlightWorkers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "in_flight_workers",
+		Help: "Workers currently downloading a scene.",
+	})
+)
+
+// startMetricsServer exposes the collectors above on addr (e.g. ":9090")
+// until ctx is cancelled.
+func startMetricsServer(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := srv.ListenAndServe(); nil != err && http.ErrServerClosed != err {
+			logger.Error().Err(err).Msg("metrics server failed")
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+}
+
+// logDownload records one completed or failed download attempt, both as a
+// structured JSON log line and as Prometheus observations.
+func logDownload(kind string, sceneId string, band int, attempt int, bytes int64, dur time.Duration, err error) {
+	ev := logger.Info()
+	if nil != err {
+		ev = logger.Error()
+		retryTotal.WithLabelValues(kind).Inc()
+	} else {
+		bytesDownloadedTotal.WithLabelValues(kind).Add(float64(bytes))
+	}
+	downloadDurationSeconds.WithLabelValues(kind).Observe(dur.Seconds())
+	ev.Str("scene_id", sceneId).
+		Int("band", band).
+		Int("attempt", attempt).
+		Int64("bytes", bytes).
+		Int64("duration_ms", dur.Milliseconds()).
+		AnErr("error", err).
+		Msg("download")
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// rawTiff is a minimal, read/patch-only view of a single-band, uncompressed
+// 16-bit GeoTIFF. It deliberately does not model the full TIFF tag set: it
+// only looks at the handful of tags needed to locate and rewrite pixel
+// data, and leaves every other byte of the file (including all the
+// georeferencing tags Landsat products carry) untouched.
+type rawTiff struct {
+	raw           []byte
+	order         binary.ByteOrder
+	width, height int
+	bitsPerSample int
+	compression   int
+	stripOffsets  []uint32
+	stripCounts   []uint32
+}
+
+const (
+	tagImageWidth      = 256
+	tagImageLength     = 257
+	tagBitsPerSample   = 258
+	tagCompression     = 259
+	tagStripOffsets    = 273
+	tagStripByteCounts = 279
+)
+
+func readRawTiff(path string) (*rawTiff, error) {
+	raw, err := os.ReadFile(path)
+	if nil != err {
+		return nil, err
+	}
+	if len(raw) < 8 {
+		return nil, fmt.Errorf("%s: too short to be a TIFF", path)
+	}
+	var order binary.ByteOrder
+	switch string(raw[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("%s: not a TIFF file", path)
+	}
+	if order.Uint16(raw[2:4]) != 42 {
+		return nil, fmt.Errorf("%s: bad TIFF magic", path)
+	}
+	t := &rawTiff{raw: raw, order: order}
+	ifdOffset := uint64(order.Uint32(raw[4:8]))
+	if ifdOffset+2 > uint64(len(raw)) {
+		return nil, fmt.Errorf("%s: IFD offset out of range", path)
+	}
+	n := order.Uint16(raw[ifdOffset : ifdOffset+2])
+	for i := uint16(0); i < n; i++ {
+		entryOffset := ifdOffset + 2 + uint64(i)*12
+		if entryOffset+12 > uint64(len(raw)) {
+			return nil, fmt.Errorf("%s: IFD entry %d out of range", path, i)
+		}
+		entry := raw[entryOffset : entryOffset+12]
+		tag := order.Uint16(entry[0:2])
+		typ := order.Uint16(entry[2:4])
+		count := order.Uint32(entry[4:8])
+		var err error
+		switch tag {
+		case tagImageWidth:
+			var v uint32
+			v, err = tiffScalar(order, typ, entry[8:12])
+			t.width = int(v)
+		case tagImageLength:
+			var v uint32
+			v, err = tiffScalar(order, typ, entry[8:12])
+			t.height = int(v)
+		case tagBitsPerSample:
+			var v uint32
+			v, err = tiffScalar(order, typ, entry[8:12])
+			t.bitsPerSample = int(v)
+		case tagCompression:
+			var v uint32
+			v, err = tiffScalar(order, typ, entry[8:12])
+			t.compression = int(v)
+		case tagStripOffsets:
+			t.stripOffsets, err = tiffArray(order, typ, count, raw, entry[8:12])
+		case tagStripByteCounts:
+			t.stripCounts, err = tiffArray(order, typ, count, raw, entry[8:12])
+		}
+		if nil != err {
+			return nil, fmt.Errorf("%s: tag %d: %w", path, tag, err)
+		}
+	}
+	if 0 == t.compression {
+		t.compression = 1
+	}
+	if 0 == len(t.stripOffsets) || len(t.stripOffsets) != len(t.stripCounts) {
+		return nil, fmt.Errorf("%s: missing strip layout", path)
+	}
+	// ImageWidth/ImageLength are attacker-controlled LONG tags with no
+	// inherent range limit; trusting their product as a make() capacity
+	// hint lets a corrupted file overflow int and panic. Cross-check it
+	// against the strip layout, which is itself bounded by the file's own
+	// size, before ever treating width*height as a count of anything.
+	var stripBytes uint64
+	for _, c := range t.stripCounts {
+		stripBytes += uint64(c)
+	}
+	if stripBytes > uint64(len(raw)) {
+		return nil, fmt.Errorf("%s: strip byte counts (%d) exceed file size (%d)", path, stripBytes, len(raw))
+	}
+	if t.width <= 0 || t.height <= 0 || uint64(t.width)*uint64(t.height) != stripBytes/2 {
+		return nil, fmt.Errorf("%s: image dimensions %dx%d don't match strip layout (%d bytes)", path, t.width, t.height, stripBytes)
+	}
+	return t, nil
+}
+
+// tiffScalar reads a single short/long value stored inline in an IFD entry.
+func tiffScalar(order binary.ByteOrder, typ uint16, v []byte) (uint32, error) {
+	if 3 == typ {
+		return uint32(order.Uint16(v[0:2])), nil
+	}
+	if 4 != typ {
+		return 0, fmt.Errorf("unsupported field type %d", typ)
+	}
+	return order.Uint32(v[0:4]), nil
+}
+
+// tiffArray reads a short/long array that is either stored inline (count
+// small enough to fit in the 4-byte value field) or out-of-line at the
+// offset the value field points to.
+func tiffArray(order binary.ByteOrder, typ uint16, count uint32, raw []byte, v []byte) ([]uint32, error) {
+	width := uint32(4)
+	if 3 == typ {
+		width = 2
+	} else if 4 != typ {
+		return nil, fmt.Errorf("unsupported field type %d", typ)
+	}
+	inline := width*count <= 4
+	var data []byte
+	if inline {
+		if uint64(width)*uint64(count) > uint64(len(v)) {
+			return nil, fmt.Errorf("inline array of %d entries out of range", count)
+		}
+		data = v
+	} else {
+		off := uint64(order.Uint32(v[0:4]))
+		end := off + uint64(width)*uint64(count)
+		if end > uint64(len(raw)) {
+			return nil, fmt.Errorf("array at offset %d (%d entries) out of range", off, count)
+		}
+		data = raw[off:end]
+	}
+	out := make([]uint32, count)
+	for i := uint32(0); i < count; i++ {
+		if 3 == typ {
+			out[i] = uint32(order.Uint16(data[i*2:]))
+		} else {
+			out[i] = order.Uint32(data[i*4:])
+		}
+	}
+	return out, nil
+}
+
+// pixels returns the image as a flat row-major slice of samples, supporting
+// the 16-bit-per-sample layout every Landsat 8 band and BQA file uses.
+func (t *rawTiff) pixels() ([]uint16, error) {
+	if 16 != t.bitsPerSample {
+		return nil, fmt.Errorf("unsupported bits per sample: %d", t.bitsPerSample)
+	}
+	if 1 != t.compression {
+		return nil, fmt.Errorf("unsupported compression: %d", t.compression)
+	}
+	out := make([]uint16, 0, t.width*t.height)
+	for i, off := range t.stripOffsets {
+		n := t.stripCounts[i] / 2
+		end := uint64(off) + uint64(n)*2
+		if end > uint64(len(t.raw)) {
+			return nil, fmt.Errorf("strip %d at offset %d (%d bytes) out of range", i, off, t.stripCounts[i])
+		}
+		strip := t.raw[off:end]
+		for j := uint32(0); j < n; j++ {
+			out = append(out, t.order.Uint16(strip[j*2:]))
+		}
+	}
+	return out, nil
+}
+
+// writeMasked writes a copy of the original file to path with its pixel
+// strips replaced by pixels, leaving every other byte - including all
+// georeferencing tags - identical to the source file.
+func (t *rawTiff) writeMasked(path string, pixels []uint16) error {
+	out := make([]byte, len(t.raw))
+	copy(out, t.raw)
+	idx := 0
+	for i, off := range t.stripOffsets {
+		n := t.stripCounts[i] / 2
+		end := uint64(off) + uint64(n)*2
+		if end > uint64(len(out)) {
+			return fmt.Errorf("strip %d at offset %d (%d bytes) out of range", i, off, t.stripCounts[i])
+		}
+		if idx+int(n) > len(pixels) {
+			return fmt.Errorf("strip %d needs %d pixels but only %d remain", i, n, len(pixels)-idx)
+		}
+		strip := out[off:end]
+		for j := uint32(0); j < n; j++ {
+			t.order.PutUint16(strip[j*2:], pixels[idx])
+			idx++
+		}
+	}
+	return os.WriteFile(path, out, 0644)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// buildTiff assembles a minimal single-strip, uncompressed 16-bit TIFF with
+// the six tags readRawTiff understands. widthTag/heightTag let a test lie
+// about the image dimensions independently of how many pixels are actually
+// written to the strip, to exercise the width*height-vs-strip-layout check.
+func buildTiff(widthTag, heightTag uint32, pixels []uint16) []byte {
+	order := binary.LittleEndian
+	var buf bytes.Buffer
+	buf.WriteString("II")
+	binary.Write(&buf, order, uint16(42))
+	binary.Write(&buf, order, uint32(8)) // IFD offset
+
+	type entry struct {
+		tag, typ uint16
+		count    uint32
+		val      uint32
+	}
+	entries := []entry{
+		{256, 4, 1, widthTag},
+		{257, 4, 1, heightTag},
+		{258, 3, 1, 16},
+		{259, 3, 1, 1},
+		{273, 4, 1, 0}, // strip offset, patched below
+		{279, 4, 1, uint32(len(pixels) * 2)},
+	}
+	ifdSize := 2 + len(entries)*12 + 4
+	stripOffset := uint32(8 + ifdSize)
+	entries[4].val = stripOffset
+
+	binary.Write(&buf, order, uint16(len(entries)))
+	for _, e := range entries {
+		binary.Write(&buf, order, e.tag)
+		binary.Write(&buf, order, e.typ)
+		binary.Write(&buf, order, e.count)
+		if 3 == e.typ {
+			binary.Write(&buf, order, uint16(e.val))
+			binary.Write(&buf, order, uint16(0))
+		} else {
+			binary.Write(&buf, order, e.val)
+		}
+	}
+	binary.Write(&buf, order, uint32(0)) // next IFD offset
+	for _, p := range pixels {
+		binary.Write(&buf, order, p)
+	}
+	return buf.Bytes()
+}
+
+func writeTemp(t *testing.T, data []byte) string {
+	path := filepath.Join(t.TempDir(), "test.tif")
+	if err := os.WriteFile(path, data, 0644); nil != err {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadRawTiffAndPixels(t *testing.T) {
+	pixels := []uint16{1, 2, 3, 4}
+	path := writeTemp(t, buildTiff(2, 2, pixels))
+
+	tiff, err := readRawTiff(path)
+	if nil != err {
+		t.Fatalf("readRawTiff: %v", err)
+	}
+	if 2 != tiff.width || 2 != tiff.height {
+		t.Fatalf("got %dx%d, want 2x2", tiff.width, tiff.height)
+	}
+	got, err := tiff.pixels()
+	if nil != err {
+		t.Fatalf("pixels: %v", err)
+	}
+	if len(got) != len(pixels) {
+		t.Fatalf("got %d pixels, want %d", len(got), len(pixels))
+	}
+	for i, v := range pixels {
+		if got[i] != v {
+			t.Errorf("pixel %d = %d, want %d", i, got[i], v)
+		}
+	}
+}
+
+// TestReadRawTiffRejectsOverflowingDimensions reproduces a 50-byte crafted
+// TIFF whose ImageWidth/ImageLength tags claim 0xFFFFFFFF while its strip
+// layout only backs 4 pixels. readRawTiff must reject it rather than let
+// pixels() later compute make([]uint16, 0, width*height) and panic.
+func TestReadRawTiffRejectsOverflowingDimensions(t *testing.T) {
+	path := writeTemp(t, buildTiff(0xFFFFFFFF, 0xFFFFFFFF, []uint16{1, 2, 3, 4}))
+
+	if _, err := readRawTiff(path); nil == err {
+		t.Fatal("expected an error for dimensions that don't match the strip layout")
+	}
+}
+
+func TestReadRawTiffRejectsMismatchedDimensions(t *testing.T) {
+	path := writeTemp(t, buildTiff(3, 3, []uint16{1, 2, 3, 4}))
+
+	if _, err := readRawTiff(path); nil == err {
+		t.Fatal("expected an error when width*height doesn't match the strip byte count")
+	}
+}
+
+func TestReadRawTiffRejectsTruncatedFile(t *testing.T) {
+	path := writeTemp(t, []byte("II*\x00"))
+
+	if _, err := readRawTiff(path); nil == err {
+		t.Fatal("expected an error for a file too short to contain an IFD")
+	}
+}
+
+func TestReadRawTiffRejectsBadMagic(t *testing.T) {
+	data := buildTiff(2, 2, []uint16{1, 2, 3, 4})
+	data[2] = 0 // corrupt the magic number field
+	data[3] = 0
+	path := writeTemp(t, data)
+
+	if _, err := readRawTiff(path); nil == err {
+		t.Fatal("expected an error for a bad TIFF magic number")
+	}
+}
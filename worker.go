@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+// downloadScene fetches one scene's metadata, optional BQA, and requested
+// bands, then pushes its id onto ids once everything has landed on disk.
+// Scenes outside the [from, to] window or acquired during daytime are
+// skipped; every other step competes for the shared semaphore so the total
+// number of in-flight HTTP requests across every scene stays bounded, while
+// a single scene's own BQA and band downloads run concurrently with each
+// other.
+func downloadScene(ctx context.Context, repo Repository, sem *semaphore.Weighted, rec []string, ids chan<- string) {
+	scene, err := repo.GetScene(rec)
+	if nil != err {
+		log.Printf("Failed to parse scene record: %s\n", err)
+		return
+	}
+	inFlightWorkers.Inc()
+	defer inFlightWorkers.Dec()
+	result := "error"
+	defer func() { scenesProcessedTotal.WithLabelValues(result).Inc() }()
+
+	if scene.Acquisition().Before(time.Time(from)) || scene.Acquisition().After(time.Time(to)) {
+		result = "skipped"
+		return
+	}
+
+	if err := sem.Acquire(ctx, 1); nil != err {
+		return
+	}
+	day, err := scene.IsDay()
+	sem.Release(1)
+	if nil != err {
+		log.Printf("Failed to determine if scene is nighttime: %v\n", err)
+		return
+	}
+	if day {
+		result = "skipped"
+		return
+	}
+
+	dir := filepath.Join(dpath, scene.Id())
+	if err := os.MkdirAll(dir, os.ModeDir|os.ModePerm); nil != err {
+		log.Printf("Failed to create storage directory: %v\n", err)
+		return
+	}
+
+	metaPath := filepath.Join(dir, scene.Id()+"_MTL.txt")
+	if err := sem.Acquire(ctx, 1); nil != err {
+		return
+	}
+	_, err = downloadOne(scene.GetMeta, metaPath)
+	sem.Release(1)
+	if nil != err {
+		removePartial(metaPath)
+		return
+	}
+
+	if targetAOI.set {
+		footprint, err := sceneFootprint(metaPath)
+		if nil != err {
+			log.Printf("Failed to read scene footprint: %v\n", err)
+		} else if !targetAOI.intersects(footprint) {
+			log.Printf("Scene %s footprint does not intersect -bbox, skipping\n", scene.Id())
+			result = "skipped"
+			return
+		}
+	}
+
+	var eg errgroup.Group
+	var bqaPath string
+	if bqa {
+		bqaPath = filepath.Join(dir, scene.Id()+"_BQA.TIF")
+		eg.Go(func() error {
+			if err := sem.Acquire(ctx, 1); nil != err {
+				return err
+			}
+			defer sem.Release(1)
+			_, err := downloadOne(scene.GetBQA, bqaPath)
+			if nil != err {
+				removePartial(bqaPath)
+			}
+			return err
+		})
+	}
+
+	bandPaths := make(map[int]string, len(bands))
+	for _, b := range bands {
+		bandPaths[b] = filepath.Join(dir, fmt.Sprintf("%s_B%d.TIF", scene.Id(), b))
+	}
+	for _, b := range bands {
+		band, path := b, bandPaths[b]
+		eg.Go(func() error {
+			if err := sem.Acquire(ctx, 1); nil != err {
+				return err
+			}
+			defer sem.Release(1)
+			_, err := downloadOne(func() (io.ReadCloser, error) { return scene.GetBand(band) }, path)
+			if nil != err {
+				removePartial(path)
+			}
+			return err
+		})
+	}
+	if err := eg.Wait(); nil != err {
+		log.Printf("Failed to download %s: %v\n", scene.Id(), err)
+		return
+	}
+
+	if doMask && "" != bqaPath {
+		for band, bandPath := range bandPaths {
+			masked := strings.TrimSuffix(bandPath, ".TIF") + "_masked.TIF"
+			if err := maskBand(bqaPath, bandPath, masked, maskCloudConf, maskShadowConf); nil != err {
+				log.Printf("Failed to mask band %d of %s: %v\n", band, scene.Id(), err)
+			}
+		}
+	}
+
+	result = "success"
+	log.Printf("Done with %s\n", scene.Id())
+	ids <- scene.Id()
+}
+
+// downloadOne creates fpath and copies the reader returned by get into it.
+// get itself already retries and reports each attempt via logDownload/
+// retryTotal in HttpRepository.get, so this only copies the resolved reader
+// into its final per-scene location.
+func downloadOne(get func() (io.ReadCloser, error), fpath string) (int64, error) {
+	out, err := os.Create(fpath)
+	if nil != err {
+		log.Printf("Failed to create output file %s: %v\n", fpath, err)
+		return 0, err
+	}
+	defer out.Close()
+	var n int64
+	rc, err := get()
+	if nil == err {
+		n, err = io.Copy(out, rc)
+	}
+	return n, err
+}